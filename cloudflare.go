@@ -0,0 +1,202 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/fabric8io/exposecontroller/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	cloudflareTunnel      = "cloudflare-tunnel"
+	cloudflareToken       = "cloudflare-token"
+	cloudflareTokenSecret = "cloudflare-token-secret"
+	cloudflareAccountID   = "cloudflare-account-id"
+	cloudflareTunnelID    = "cloudflare-tunnel-id"
+	cloudflareAPIBase     = "https://api.cloudflare.com/client/v4"
+)
+
+// cloudflareConfig holds the credentials needed to reconcile a Cloudflare Tunnel.
+type cloudflareConfig struct {
+	Token     string
+	AccountID string
+	TunnelID  string
+}
+
+type cloudflareIngressRule struct {
+	Hostname string `json:"hostname,omitempty"`
+	Service  string `json:"service"`
+}
+
+type cloudflareTunnelConfiguration struct {
+	Ingress []cloudflareIngressRule `json:"ingress"`
+}
+
+// lastPushedTunnelConfig remembers the last configuration successfully pushed to each
+// tunnel, keyed by tunnel ID, so useCloudflareTunnel - re-run on every service sync event
+// and on every informer resync of every service in the cluster - only calls the Cloudflare
+// API when the desired configuration actually changed.
+var (
+	lastPushedTunnelConfigMu sync.Mutex
+	lastPushedTunnelConfig   = map[string]cloudflareTunnelConfiguration{}
+)
+
+// getCloudflareConfig reads the account id, tunnel id and API token from the exposecontroller
+// ConfigMap, falling back to a referenced Secret for the token so it need not be stored in plain text.
+func getCloudflareConfig(c kubernetes.Interface, environment *corev1.ConfigMap, currentNs string) (*cloudflareConfig, error) {
+	accountID, ok := environment.Data[cloudflareAccountID]
+	if !ok {
+		return nil, fmt.Errorf("no ConfigMap data with name %s found, cannot use the %s expose-rule", cloudflareAccountID, cloudflareTunnel)
+	}
+	tunnelID, ok := environment.Data[cloudflareTunnelID]
+	if !ok {
+		return nil, fmt.Errorf("no ConfigMap data with name %s found, cannot use the %s expose-rule", cloudflareTunnelID, cloudflareTunnel)
+	}
+
+	token := environment.Data[cloudflareToken]
+	if token == "" {
+		secretName, ok := environment.Data[cloudflareTokenSecret]
+		if !ok {
+			return nil, fmt.Errorf("no ConfigMap data with name %s or %s found, cannot use the %s expose-rule", cloudflareToken, cloudflareTokenSecret, cloudflareTunnel)
+		}
+		secret, err := c.CoreV1().Secrets(currentNs).Get(secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Secret %s in namespace %s for the %s expose-rule: %v", secretName, currentNs, cloudflareTunnel, err)
+		}
+		token = string(secret.Data["token"])
+	}
+
+	return &cloudflareConfig{Token: token, AccountID: accountID, TunnelID: tunnelID}, nil
+}
+
+// useCloudflareTunnel aggregates every expose=true service in the cluster into a set of
+// exposures (hostname -> service.namespace.svc:port) and reconciles them against the
+// configured Cloudflare Tunnel's ingress configuration.
+func useCloudflareTunnel(c kubernetes.Interface, domain string, environment *corev1.ConfigMap, currentNs string) error {
+	cfg, err := getCloudflareConfig(c, environment, currentNs)
+	if err != nil {
+		return err
+	}
+
+	services, err := c.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+
+	exposeLabelKey, exposeLabelValue := getExposeLabel()
+	config := cloudflareTunnelConfiguration{}
+	exposed := []*corev1.Service{}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.ObjectMeta.Labels[exposeLabelKey] != exposeLabelValue {
+			continue
+		}
+		port := firstServicePort(svc)
+		if port == 0 {
+			continue
+		}
+		hostName := svc.ObjectMeta.Name + "." + domain
+		config.Ingress = append(config.Ingress, cloudflareIngressRule{
+			Hostname: hostName,
+			Service:  fmt.Sprintf("http://%s.%s.svc:%d", svc.ObjectMeta.Name, svc.ObjectMeta.Namespace, port),
+		})
+		exposed = append(exposed, svc)
+	}
+	// Cloudflare requires the ingress list to end in a catch-all rule
+	config.Ingress = append(config.Ingress, cloudflareIngressRule{Service: "http_status:404"})
+
+	lastPushedTunnelConfigMu.Lock()
+	unchanged := reflect.DeepEqual(lastPushedTunnelConfig[cfg.TunnelID], config)
+	lastPushedTunnelConfigMu.Unlock()
+
+	if !unchanged {
+		if err := cfg.updateTunnelConfiguration(&config); err != nil {
+			return fmt.Errorf("failed to update cloudflare tunnel %s configuration: %v", cfg.TunnelID, err)
+		}
+		lastPushedTunnelConfigMu.Lock()
+		lastPushedTunnelConfig[cfg.TunnelID] = config
+		lastPushedTunnelConfigMu.Unlock()
+	}
+
+	for _, svc := range exposed {
+		addServiceAnnotationURL(c, svc.Namespace, svc, "https://"+svc.ObjectMeta.Name+"."+domain, false)
+	}
+	util.Successf("Reconciled %d services against cloudflare tunnel %s", len(exposed), cfg.TunnelID)
+	return nil
+}
+
+func firstServicePort(svc *corev1.Service) int32 {
+	if len(svc.Spec.Ports) == 0 {
+		return 0
+	}
+	return svc.Spec.Ports[0].Port
+}
+
+// addServiceAnnotationURL sets the fabric8.io/exposeUrl annotation to an already-built URL,
+// for expose-rules such as cloudflare-tunnel where the scheme can't be inferred from a port.
+func addServiceAnnotationURL(c kubernetes.Interface, ns string, svc *corev1.Service, url string, hasServiceChanged bool) error {
+	existingExposeURL := svc.Annotations[exposeAnnotationKey]
+	if existingExposeURL != url || hasServiceChanged {
+		if svc.Annotations == nil {
+			svc.Annotations = map[string]string{}
+		}
+		svc.Annotations[exposeAnnotationKey] = url
+		_, err := c.CoreV1().Services(ns).Update(svc)
+		if err != nil {
+			util.Warnf("Failed to add the %s to service %s %v", exposeAnnotationKey, svc.Name, err)
+			return err
+		}
+		util.Successf("Added %s %s annotation to service %s", url, exposeAnnotationKey, svc.Name)
+	}
+	return nil
+}
+
+func (cfg *cloudflareConfig) updateTunnelConfiguration(config *cloudflareTunnelConfiguration) error {
+	body, err := json.Marshal(struct {
+		Config cloudflareTunnelConfiguration `json:"config"`
+	}{Config: *config})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/accounts/%s/cfd_tunnel/%s/configurations", cloudflareAPIBase, cfg.AccountID, cfg.TunnelID)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("cloudflare API returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestFirstServicePort(t *testing.T) {
+	if got := firstServicePort(&corev1.Service{}); got != 0 {
+		t.Errorf("no ports: firstServicePort() = %d, want 0", got)
+	}
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}, {Port: 8443}}}}
+	if got := firstServicePort(svc); got != 8080 {
+		t.Errorf("firstServicePort() = %d, want 8080", got)
+	}
+}
+
+func TestAddServiceAnnotationURL(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "myapp", Namespace: "default"}}
+	client := fake.NewSimpleClientset(svc)
+
+	// A nil Annotations map must not panic on the first write.
+	if err := addServiceAnnotationURL(client, "default", svc, "https://myapp.example.com", false); err != nil {
+		t.Fatalf("addServiceAnnotationURL() error = %v", err)
+	}
+	stored, err := client.CoreV1().Services("default").Get("myapp", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := stored.Annotations[exposeAnnotationKey]; got != "https://myapp.example.com" {
+		t.Errorf("%s = %q, want https://myapp.example.com", exposeAnnotationKey, got)
+	}
+
+	// An unchanged URL and hasServiceChanged=false must not issue another Update.
+	client.PrependReactor("update", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		t.Fatalf("unexpected Update for an unchanged exposeUrl")
+		return false, nil, nil
+	})
+	if err := addServiceAnnotationURL(client, "default", stored, "https://myapp.example.com", false); err != nil {
+		t.Fatalf("addServiceAnnotationURL() error = %v", err)
+	}
+}
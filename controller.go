@@ -0,0 +1,287 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fabric8io/exposecontroller/util"
+	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
+	routeinformers "github.com/openshift/client-go/route/informers/externalversions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var (
+	reconcileTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exposecontroller_reconcile_total",
+		Help: "Total number of service reconciliations processed",
+	})
+	reconcileErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exposecontroller_reconcile_errors_total",
+		Help: "Total number of service reconciliations that returned an error",
+	})
+	syncLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "exposecontroller_sync_latency_seconds",
+		Help: "Latency of a single syncService call",
+	})
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exposecontroller_queue_depth",
+		Help: "Current depth of the service workqueue",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reconcileTotal, reconcileErrorsTotal, syncLatency, queueDepth)
+}
+
+// Controller watches Services, Endpoints, Nodes and Ingresses (plus Routes, through a
+// separate OpenShift informer factory) and reconciles the configured expose-rule for every
+// expose=true service through a rate-limited workqueue. Every event - add, update, delete,
+// a node address changing, or an externally-modified Ingress/Route - just enqueues a
+// namespace/name key; syncService is the single idempotent function that computes and
+// applies desired state for that key, so a failed reconcile is retried instead of silently
+// dropped, and an externally edited or deleted Ingress/Route gets repaired on its own.
+type Controller struct {
+	client          kubernetes.Interface
+	routeClient     routeclientset.Interface
+	currentNs       string
+	queue           workqueue.RateLimitingInterface
+	nodes           *nodeCache
+	informers       informers.SharedInformerFactory
+	routeInformers  routeinformers.SharedInformerFactory
+	endpointsLister corelisters.EndpointsLister
+}
+
+// NewController wires up the Service, Endpoints, Node, Ingress and Route informers and the
+// workqueue. Call Run to start processing once this process is elected leader.
+func NewController(client kubernetes.Interface, routeClient routeclientset.Interface, currentNs string, resyncPeriod time.Duration) *Controller {
+	ctrl := &Controller{
+		client:         client,
+		routeClient:    routeClient,
+		currentNs:      currentNs,
+		queue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		nodes:          newNodeCache(),
+		informers:      informers.NewSharedInformerFactory(client, resyncPeriod),
+		routeInformers: routeinformers.NewSharedInformerFactory(routeClient, resyncPeriod),
+	}
+	ctrl.endpointsLister = ctrl.informers.Core().V1().Endpoints().Lister()
+
+	ctrl.informers.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueService,
+		UpdateFunc: func(old, new interface{}) { ctrl.enqueueService(new) },
+		DeleteFunc: ctrl.enqueueService,
+	})
+
+	ctrl.informers.Core().V1().Endpoints().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueService,
+		UpdateFunc: func(old, new interface{}) { ctrl.enqueueService(new) },
+		DeleteFunc: ctrl.enqueueService,
+	})
+
+	ctrl.informers.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { ctrl.resyncNodeAddresses() },
+		UpdateFunc: func(old, new interface{}) { ctrl.resyncNodeAddresses() },
+		DeleteFunc: func(obj interface{}) { ctrl.resyncNodeAddresses() },
+	})
+
+	ctrl.informers.Extensions().V1beta1().Ingresses().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueIngress,
+		UpdateFunc: func(old, new interface{}) { ctrl.enqueueIngress(new) },
+		DeleteFunc: ctrl.enqueueIngress,
+	})
+
+	ctrl.routeInformers.Route().V1().Routes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueService,
+		UpdateFunc: func(old, new interface{}) { ctrl.enqueueService(new) },
+		DeleteFunc: ctrl.enqueueService,
+	})
+
+	return ctrl
+}
+
+func (ctrl *Controller) enqueueService(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		util.Warnf("Unable to compute a workqueue key for %v %v", obj, err)
+		return
+	}
+	ctrl.queue.Add(key)
+	queueDepth.Set(float64(ctrl.queue.Len()))
+}
+
+// enqueueIngress re-reconciles the service that owns obj, an Ingress. In path mode every
+// service shares the single sharedIngressName Ingress, so a change to that one object
+// re-enqueues every service in its namespace instead of a non-existent "owning" service.
+func (ctrl *Controller) enqueueIngress(obj interface{}) {
+	ingress, ok := obj.(*extensionsv1beta1.Ingress)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			util.Warnf("Unable to recover an Ingress from tombstone %v", obj)
+			return
+		}
+		ingress, ok = tombstone.Obj.(*extensionsv1beta1.Ingress)
+		if !ok {
+			util.Warnf("Tombstone contained unexpected object %v", tombstone.Obj)
+			return
+		}
+	}
+
+	if ingress.Name == sharedIngressName {
+		ctrl.resyncNamespaceServices(ingress.Namespace)
+		return
+	}
+	ctrl.enqueueService(obj)
+}
+
+// resyncNamespaceServices re-enqueues every service in ns, used when a change can't be
+// attributed to a single owning service (the shared path-mode Ingress).
+func (ctrl *Controller) resyncNamespaceServices(ns string) {
+	services, err := ctrl.client.CoreV1().Services(ns).List(metav1.ListOptions{})
+	if err != nil {
+		util.Errorf("Error listing services in namespace %s to resync shared ingress %v", ns, err)
+		return
+	}
+	for i := range services.Items {
+		ctrl.enqueueService(&services.Items[i])
+	}
+}
+
+// resyncNodeAddresses refreshes the Ready-node address cache and re-enqueues every service
+// so NodePort-exposed services are re-reconciled whenever the set of Ready node addresses
+// changes, instead of only on their own add/update/delete.
+func (ctrl *Controller) resyncNodeAddresses() {
+	nodeList, err := ctrl.client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		util.Errorf("Error getting nodes %v", err)
+		return
+	}
+	ctrl.nodes.update(nodeList, getNodeIPOverride(ctrl.client, ctrl.currentNs))
+
+	services, err := ctrl.client.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		util.Errorf("Error listing services to resync NodePort addresses %v", err)
+		return
+	}
+	for i := range services.Items {
+		ctrl.enqueueService(&services.Items[i])
+	}
+}
+
+// Run starts the informers and a pool of workers draining the workqueue. It blocks until
+// stopCh is closed.
+func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+
+	ctrl.informers.Start(stopCh)
+	ctrl.informers.WaitForCacheSync(stopCh)
+
+	// The Route API group doesn't exist on a plain Kubernetes cluster, so its reflector
+	// would List/Watch 404 forever and WaitForCacheSync would block Run from ever reaching
+	// the worker loop below. Only start it where Routes are actually served.
+	if util.TypeOfMaster(ctrl.client) == util.OpenShift {
+		ctrl.routeInformers.Start(stopCh)
+		ctrl.routeInformers.WaitForCacheSync(stopCh)
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+}
+
+func (ctrl *Controller) runWorker() {
+	for ctrl.processNextItem() {
+	}
+}
+
+func (ctrl *Controller) processNextItem() bool {
+	key, shutdown := ctrl.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer ctrl.queue.Done(key)
+	queueDepth.Set(float64(ctrl.queue.Len()))
+
+	start := time.Now()
+	err := ctrl.syncService(key.(string))
+	syncLatency.Observe(time.Since(start).Seconds())
+	reconcileTotal.Inc()
+
+	if err == nil {
+		ctrl.queue.Forget(key)
+		return true
+	}
+
+	reconcileErrorsTotal.Inc()
+	util.Warnf("Error syncing service %s, retrying: %v", key, err)
+	ctrl.queue.AddRateLimited(key)
+	return true
+}
+
+// syncService is the single idempotent reconcile function: it fetches the live Service (if
+// any exists) and computes/applies the desired expose-rule state for it, diffing against
+// what's actually on the cluster rather than acting on the stale object an event captured.
+// A service that still exists but no longer carries the expose=true label is torn down the
+// same as one that was deleted outright, so a label removal cleans up its Ingress/Route.
+func (ctrl *Controller) syncService(key string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("invalid service key %s: %v", key, err)
+	}
+
+	svc, err := ctrl.client.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return deleteExposeRule(ns, name, ctrl.client, ctrl.routeClient, ctrl.currentNs)
+	}
+	if err != nil {
+		return err
+	}
+
+	exposeLabelKey, exposeLabelValue := getExposeLabel()
+	if svc.ObjectMeta.Labels[exposeLabelKey] != exposeLabelValue {
+		return deleteExposeRule(ns, name, ctrl.client, ctrl.routeClient, ctrl.currentNs)
+	}
+
+	return addExposeRule(ctrl.client, ctrl.routeClient, svc, ctrl.currentNs, ctrl.nodes, ctrl.endpointsLister)
+}
+
+// ServeHealth exposes /healthz, /readyz and Prometheus /metrics on addr.
+func ServeHealth(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -16,27 +16,25 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/fabric8io/exposecontroller/client"
 	"github.com/fabric8io/exposecontroller/util"
-	osclient "github.com/openshift/origin/pkg/client"
-	rapi "github.com/openshift/origin/pkg/route/api"
-	rapiv1 "github.com/openshift/origin/pkg/route/api/v1"
-	"k8s.io/kubernetes/pkg/api"
-	kapi "k8s.io/kubernetes/pkg/api"
-	"k8s.io/kubernetes/pkg/apis/extensions"
-	"k8s.io/kubernetes/pkg/client/cache"
-	kclient "k8s.io/kubernetes/pkg/client/unversioned"
-	"k8s.io/kubernetes/pkg/controller/framework"
+	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
-	"k8s.io/kubernetes/pkg/runtime"
-	"k8s.io/kubernetes/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
 const (
@@ -50,20 +48,29 @@ const (
 	route               = "route"
 	exposeLabel         = "expose=true"
 	watchRate           = "watch-rate-milliseconds"
-	externalIPLabel     = "kubernetes.io/externalIP"
+
+	leaderElectionLockName = exposeControllerCM + "-leader"
+	healthAddr             = ":8080"
+	workerCount            = 4
 )
 
 func main() {
 
 	f := cmdutil.NewFactory(nil)
-	c, cfg := client.NewClient(f)
-	oc, _ := client.NewOpenShiftClient(cfg)
+	_, cfg := client.NewClient(f)
+
+	c, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		log.Fatalf("Unable to build a Kubernetes clientset: %v", err)
+	}
+	routeClient, err := routeclientset.NewForConfig(cfg)
+	if err != nil {
+		log.Fatalf("Unable to build an OpenShift route clientset: %v", err)
+	}
 
 	util.Successf("Connected")
 
-	var err error
 	currentNs := os.Getenv("KUBERNETES_NAMESPACE")
-
 	if currentNs == "" {
 		currentNs, _, err = f.DefaultNamespace()
 		if err != nil {
@@ -73,33 +80,46 @@ func main() {
 
 	resyncPeriod := getResyncPeriod(c, currentNs)
 	log.Printf("ResyncPeriod is %v", resyncPeriod)
+	warnIfACMEFallbackUnavailable(c, currentNs)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Unable to determine hostname for leader election identity: %v", err)
+	}
+
+	ctrl := NewController(c, routeClient, currentNs, resyncPeriod)
+
+	go func() {
+		log.Fatal(ServeHealth(healthAddr))
+	}()
 
-	_, controller := framework.NewInformer(
-		&cache.ListWatch{
-			ListFunc: func(options api.ListOptions) (runtime.Object, error) {
-				return c.Services(api.NamespaceAll).List(options)
+	lock := &resourcelock.EndpointsLock{
+		EndpointsMeta: metav1.ObjectMeta{Namespace: currentNs, Name: leaderElectionLockName},
+		Client:        c.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: hostname,
+		},
+	}
+
+	leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stopCh <-chan struct{}) {
+				util.Successf("Became leader %s, starting reconciliation", hostname)
+				ctrl.Run(workerCount, stopCh)
 			},
-			WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
-				return c.Services(api.NamespaceAll).Watch(options)
+			OnStoppedLeading: func() {
+				log.Printf("%s lost leadership, stopping reconciliation", hostname)
 			},
 		},
-		&api.Service{},
-		resyncPeriod,
-		framework.ResourceEventHandlerFuncs{
-			AddFunc:    serviceAdded(c, oc, currentNs),
-			UpdateFunc: serviceUpdated(c, oc, currentNs),
-			DeleteFunc: serviceDeleted(c, oc, currentNs),
-		},
-	)
-	stop := make(chan struct{})
-	defer close(stop)
-	go controller.Run(stop)
-
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	})
 }
 
-func getResyncPeriod(c *kclient.Client, currentNs string) time.Duration {
-	environment, err := c.ConfigMaps(currentNs).Get(exposeControllerCM)
+func getResyncPeriod(c kubernetes.Interface, currentNs string) time.Duration {
+	environment, err := c.CoreV1().ConfigMaps(currentNs).Get(exposeControllerCM, metav1.GetOptions{})
 	if err != nil {
 		log.Fatalf("No ConfigMap with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8? %v", exposeControllerCM, currentNs, err)
 	}
@@ -115,112 +135,72 @@ func getResyncPeriod(c *kclient.Client, currentNs string) time.Duration {
 	return time.Millisecond * 5000 // default of 5 seconds
 }
 
-func serviceAdded(c *kclient.Client, oc *osclient.Client, currentNs string) func(obj interface{}) {
-	return func(obj interface{}) {
-		svc := obj.(*api.Service)
-		addExposeRule(c, oc, svc, currentNs)
-	}
-}
-
-// if expose=true label has been removed or set to false delete rules
-// otherwise if not rule exists or the rule has changed create it
-func serviceUpdated(c *kclient.Client, oc *osclient.Client, currentNs string) func(oldObj interface{}, newObj interface{}) {
-	return func(oldObj interface{}, newObj interface{}) {
-		exposeLabelKey, exposeLabelValue := getExposeLabel()
-		oldSvc := oldObj.(*api.Service)
-		oldServiceLabels := oldSvc.ObjectMeta.Labels
-
-		newSvc := newObj.(*api.Service)
-		newServiceLabels := newSvc.ObjectMeta.Labels
-
-		//if expose=true label has been removed or changed and set to false then delete rules
-		if _, oldFound := oldServiceLabels[exposeLabelKey]; oldFound {
-			if newValue, newFound := newServiceLabels[exposeLabelKey]; !newFound || newValue == "false" {
-				// delete
-				deleteExposeRule(newSvc.Namespace, newSvc.ObjectMeta.Name, c, oc, currentNs)
-				return
-			}
-		}
+// addExposeRule computes and applies the desired expose-rule state for svc. It is the
+// idempotent per-service half of syncService: safe to call repeatedly for the same
+// service, and returns an error instead of killing the process so the workqueue can retry.
+func addExposeRule(c kubernetes.Interface, routeClient routeclientset.Interface, svc *corev1.Service, currentNs string, nodes *nodeCache, endpointsLister corelisters.EndpointsLister) error {
 
-		newValue, _ := newServiceLabels[exposeLabelKey]
-		if newValue == exposeLabelValue {
-			addExposeRule(c, oc, newSvc, currentNs)
-		}
-	}
-}
-
-func serviceDeleted(c *kclient.Client, oc *osclient.Client, currentNs string) func(obj interface{}) {
-	return func(obj interface{}) {
-		svc, ok := obj.(cache.DeletedFinalStateUnknown)
-		if ok {
-			// service key is in the form namespace/name
-			ns := strings.Split(svc.Key, "/")[0]
-			name := strings.Split(svc.Key, "/")[1]
-			deleteExposeRule(ns, name, c, oc, currentNs)
-		} else {
-			svc, ok := obj.(*api.Service)
-			if ok {
-				deleteExposeRule(svc.Namespace, svc.ObjectMeta.Name, c, oc, currentNs)
-			} else {
-				log.Fatalf("Error getting details of deleted service")
-			}
-		}
-	}
-}
-
-func addExposeRule(c *kclient.Client, oc *osclient.Client, svc *api.Service, currentNs string) {
-
-	environment, err := c.ConfigMaps(currentNs).Get(exposeControllerCM)
+	environment, err := c.CoreV1().ConfigMaps(currentNs).Get(exposeControllerCM, metav1.GetOptions{})
 	if err != nil {
-		log.Fatalf("No ConfigMap with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8? %v", exposeControllerCM, currentNs, err)
+		return fmt.Errorf("no ConfigMap with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8? %v", exposeControllerCM, currentNs, err)
 	}
 
 	d, ok := environment.Data[domain]
 	if !ok {
-		log.Fatalf("No ConfigMap data with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8? %v", domain, currentNs, err)
+		return fmt.Errorf("no ConfigMap data with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8?", domain, currentNs)
 	}
 
 	switch environment.Data[exposeRule] {
 	case ingress:
 		if util.TypeOfMaster(c) == util.OpenShift {
 			log.Println("Ingress is not currently supported on OpenShift, please use Routes")
-		} else {
-			err := createIngress(svc.Namespace, d, svc, c)
-			if err != nil {
-				log.Printf("Unable to create ingress rule for service %s %v", svc.ObjectMeta.Name, err)
-			}
+			return nil
 		}
+		return createIngress(svc.Namespace, d, svc, c, environment)
 
 	case route:
 		if util.TypeOfMaster(c) != util.OpenShift {
 			log.Println("Routes are only available on OpenShift, please use Ingress")
-		} else {
-			createRoute(svc.Namespace, d, svc, c, oc)
+			return nil
 		}
+		return createRoute(svc.Namespace, d, svc, c, routeClient, environment)
+
 	case nodePort:
-		useNodePort(svc.Namespace, svc, c)
+		return useNodePort(svc.Namespace, svc, c, nodes, environment, endpointsLister)
 
 	case loadBalancer:
-		useLoadBalancer(svc.Namespace, svc, c)
+		return useLoadBalancer(svc.Namespace, svc, c)
+
+	case cloudflareTunnel:
+		return useCloudflareTunnel(c, d, environment, currentNs)
 
 	default:
-		log.Fatalf("No match for %s expose-rule found.  Was the exposecontroller namespace setup by gofabric8?", environment.Data[exposeRule])
+		return fmt.Errorf("no match for %s expose-rule found.  Was the exposecontroller namespace setup by gofabric8?", environment.Data[exposeRule])
 	}
 }
 
-func deleteExposeRule(ns string, name string, c *kclient.Client, oc *osclient.Client, currentNs string) error {
+// deleteExposeRule is the idempotent teardown half of syncService, run when a service no
+// longer exists or no longer carries the expose=true label.
+func deleteExposeRule(ns string, name string, c kubernetes.Interface, routeClient routeclientset.Interface, currentNs string) error {
 
-	environment, err := c.ConfigMaps(currentNs).Get(exposeControllerCM)
+	environment, err := c.CoreV1().ConfigMaps(currentNs).Get(exposeControllerCM, metav1.GetOptions{})
 	if err != nil {
-		log.Fatalf("No ConfigMap with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8? %v", exposeControllerCM, currentNs, err)
+		return fmt.Errorf("no ConfigMap with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8? %v", exposeControllerCM, currentNs, err)
 	}
 
 	switch environment.Data[exposeRule] {
 	case ingress:
+		if isPathMode(environment) {
+			d, ok := environment.Data[domain]
+			if !ok {
+				return fmt.Errorf("no ConfigMap data with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8?", domain, currentNs)
+			}
+			return reconcileSharedIngress(ns, d, c, environment)
+		}
 		return deleteIngress(ns, name, c)
 
 	case route:
-		return deleteRoute(ns, name, oc)
+		return deleteRoute(ns, name, routeClient)
 
 	case nodePort:
 		return nil
@@ -228,19 +208,20 @@ func deleteExposeRule(ns string, name string, c *kclient.Client, oc *osclient.Cl
 	case loadBalancer:
 		return nil
 
+	case cloudflareTunnel:
+		d, ok := environment.Data[domain]
+		if !ok {
+			return fmt.Errorf("no ConfigMap data with name %s found in namespace %s.  Was the exposecontroller namespace setup by gofabric8?", domain, currentNs)
+		}
+		return useCloudflareTunnel(c, d, environment, currentNs)
+
 	default:
-		log.Fatalf("No match for %s expose-rule found.  Was the exposecontroller namespace setup by gofabric8?", environment.Data[exposeRule])
+		return fmt.Errorf("no match for %s expose-rule found.  Was the exposecontroller namespace setup by gofabric8?", environment.Data[exposeRule])
 	}
-
-	return nil
 }
 
-func deleteIngress(ns string, name string, c *kclient.Client) error {
-	rapi.AddToScheme(kapi.Scheme)
-	rapiv1.AddToScheme(kapi.Scheme)
-
-	ingressClient := c.Extensions().Ingress(ns)
-	err := ingressClient.Delete(name, nil)
+func deleteIngress(ns string, name string, c kubernetes.Interface) error {
+	err := c.ExtensionsV1beta1().Ingresses(ns).Delete(name, nil)
 	if err != nil {
 		log.Printf("Failed to delete ingress in namespace %s with error %v", ns, err)
 		return err
@@ -250,12 +231,8 @@ func deleteIngress(ns string, name string, c *kclient.Client) error {
 	return nil
 }
 
-func deleteRoute(ns string, name string, c *osclient.Client) error {
-
-	rapi.AddToScheme(kapi.Scheme)
-	rapiv1.AddToScheme(kapi.Scheme)
-
-	err := c.Routes(ns).Delete(name)
+func deleteRoute(ns string, name string, routeClient routeclientset.Interface) error {
+	err := routeClient.RouteV1().Routes(ns).Delete(name, nil)
 	if err != nil {
 		log.Printf("Failed to delete openshift route %s in namespace %s with error %v", name, ns, err)
 		return err
@@ -265,61 +242,19 @@ func deleteRoute(ns string, name string, c *osclient.Client) error {
 	return nil
 }
 
-func useNodePort(ns string, svc *api.Service, c *kclient.Client) error {
-	serviceLabels := svc.ObjectMeta.Labels
-	exposeLabelKey, exposeLabelValue := getExposeLabel()
-	updated := false
-	if serviceLabels[exposeLabelKey] == exposeLabelValue {
-		if svc.Spec.Type != api.ServiceTypeNodePort {
-			svc.Spec.Type = api.ServiceTypeNodePort
-			updated = true
-		}
-
-		if len(svc.Spec.Ports) > 1 {
-			util.Warnf("Found %v ports %s", len(svc.Spec.Ports), svc.Name)
-		}
-
-		nodes, err := c.Nodes().List(api.ListOptions{})
-		if err != nil {
-			util.Errorf("Error getting nodes %v", err)
-		}
-		if len(nodes.Items) > 1 {
-			util.Errorf("Using NodePorts on clusters of more than one node is not yet supported; unable to annotate service %s", svc.Name)
-		}
-
-		var ip string
-		for _, node := range nodes.Items {
-			ip = node.ObjectMeta.Annotations[externalIPLabel]
-		}
-		if ip == "" {
-			util.Errorf("Unable to find %s label, was gofabric8 used to deploy?", externalIPLabel)
-			return nil
-		}
-		for _, port := range svc.Spec.Ports {
-			nodePort := strconv.Itoa(port.NodePort)
-			hostName := ip + ":" + nodePort
-			util.Successf("Updating service %s using NodePort", svc.ObjectMeta.Name)
-			addServiceAnnotation(c, ns, svc, hostName, updated)
-		}
-	} else {
-		log.Printf("Skipping service %s", svc.ObjectMeta.Name)
-	}
-	return nil
-}
-
-func useLoadBalancer(ns string, svc *api.Service, c *kclient.Client) error {
+func useLoadBalancer(ns string, svc *corev1.Service, c kubernetes.Interface) error {
 	serviceLabels := svc.ObjectMeta.Labels
 	exposeLabelKey, exposeLabelValue := getExposeLabel()
 	updated := false
 	if serviceLabels[exposeLabelKey] == exposeLabelValue {
-		if svc.Spec.Type != api.ServiceTypeLoadBalancer {
-			svc.Spec.Type = api.ServiceTypeLoadBalancer
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			svc.Spec.Type = corev1.ServiceTypeLoadBalancer
 			util.Successf("Updating service %s using LoadBalancer. This can take a few minutes to be create by cloud provider", svc.ObjectMeta.Name)
 			updated = true
 		}
 		hostName := svc.Spec.LoadBalancerIP
 		if hostName != "" {
-			addServiceAnnotation(c, ns, svc, hostName, updated)
+			return addServiceAnnotation(c, ns, svc, hostName, updated, false)
 		}
 	} else {
 		log.Printf("Skipping service %s", svc.ObjectMeta.Name)
@@ -328,11 +263,14 @@ func useLoadBalancer(ns string, svc *api.Service, c *kclient.Client) error {
 	return nil
 }
 
-func createIngress(ns string, domain string, service *api.Service, c *kclient.Client) error {
-	rapi.AddToScheme(kapi.Scheme)
-	rapiv1.AddToScheme(kapi.Scheme)
+func createIngress(ns string, domain string, service *corev1.Service, c kubernetes.Interface, environment *corev1.ConfigMap) error {
+	if isPathMode(environment) {
+		return reconcileSharedIngress(ns, domain, c, environment)
+	}
 
-	ingressClient := c.Extensions().Ingress(ns)
+	ingressClient := c.ExtensionsV1beta1().Ingresses(ns)
+	tls := getTLSSettings(environment)
+	annotations := getIngressAnnotations(service, environment)
 
 	var labels = make(map[string]string)
 	labels["provider"] = "fabric8"
@@ -344,21 +282,22 @@ func createIngress(ns string, domain string, service *api.Service, c *kclient.Cl
 	exposeLabelKey, exposeLabelValue := getExposeLabel()
 
 	if serviceLabels[exposeLabelKey] == exposeLabelValue {
-		ingress, err := ingressClient.Get(name)
+		ingress, err := ingressClient.Get(name, metav1.GetOptions{})
 
 		if err != nil {
-			ports := serviceSpec.Ports
+			ports := selectServicePort(serviceSpec.Ports, annotations.Port)
 
 			if len(ports) > 0 {
-				rules := []extensions.IngressRule{}
+				rules := []extensionsv1beta1.IngressRule{}
 				for _, port := range ports {
-					rule := extensions.IngressRule{
+					rule := extensionsv1beta1.IngressRule{
 						Host: hostName,
-						IngressRuleValue: extensions.IngressRuleValue{
-							HTTP: &extensions.HTTPIngressRuleValue{
-								Paths: []extensions.HTTPIngressPath{
+						IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+							HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+								Paths: []extensionsv1beta1.HTTPIngressPath{
 									{
-										Backend: extensions.IngressBackend{
+										Path: ingressPath(annotations),
+										Backend: extensionsv1beta1.IngressBackend{
 											ServiceName: name,
 											// we need to use target port until https://github.com/nginxinc/kubernetes-ingress/issues/41 is fixed
 											//ServicePort: intstr.FromInt(port.Port),
@@ -371,15 +310,17 @@ func createIngress(ns string, domain string, service *api.Service, c *kclient.Cl
 					}
 					rules = append(rules, rule)
 				}
-				ingress := extensions.Ingress{
-					ObjectMeta: kapi.ObjectMeta{
+				ingress := extensionsv1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
 						Labels: labels,
 						Name:   name,
 					},
-					Spec: extensions.IngressSpec{
+					Spec: extensionsv1beta1.IngressSpec{
 						Rules: rules,
 					},
 				}
+				applyTLSToIngress(&ingress, tls, hostName, ns)
+				applyIngressAnnotations(&ingress, annotations)
 				// lets create the ingress
 				_, err = ingressClient.Create(&ingress)
 				if err != nil {
@@ -388,27 +329,36 @@ func createIngress(ns string, domain string, service *api.Service, c *kclient.Cl
 				}
 				util.Successf("Exposed service %s using ingress rule", name)
 			}
-		} else if len(ingress.Spec.Rules) > 0 && ingress.Spec.Rules[0].Host != hostName {
-			ingress.Spec.Rules[0].Host = hostName
-			ingressClient.Update(ingress)
-			util.Successf("Updated ingress %s with hostname %s", ingress.Name, hostName)
-
+		} else {
+			before := ingress.DeepCopy()
+			if len(ingress.Spec.Rules) > 0 {
+				ingress.Spec.Rules[0].Host = hostName
+			}
+			applyTLSToIngress(ingress, tls, hostName, ns)
+			applyIngressAnnotations(ingress, annotations)
+			if !reflect.DeepEqual(before.Spec, ingress.Spec) || !reflect.DeepEqual(before.Annotations, ingress.Annotations) {
+				if _, err := ingressClient.Update(ingress); err != nil {
+					log.Printf("Failed to update the ingress %s with error %v", name, err)
+					return err
+				}
+				util.Successf("Updated ingress %s with hostname %s", ingress.Name, hostName)
+			}
 		}
-		addServiceAnnotation(c, ns, service, hostName, false)
-	} else {
-		log.Printf("Skipping service %s", name)
+		return addServiceAnnotation(c, ns, service, hostName, false, tls.Enabled)
 	}
+	log.Printf("Skipping service %s", name)
 	return nil
 }
 
-func addServiceAnnotation(c *kclient.Client, ns string, svc *api.Service, hostName string, hasServiceChanged bool) {
+func addServiceAnnotation(c kubernetes.Interface, ns string, svc *corev1.Service, hostName string, hasServiceChanged bool, forceHTTPS bool) error {
 
 	// default to http
 	protocol := "http"
 
-	// if a port is on the hostname check is its a default http / https port
-	ports := strings.Split(hostName, ":")
-	if len(ports) == 2 {
+	if forceHTTPS {
+		protocol = "https"
+	} else if ports := strings.Split(hostName, ":"); len(ports) == 2 {
+		// if a port is on the hostname check is its a default http / https port
 		if ports[1] == "443" || ports[1] == "8443" {
 			protocol = "https"
 		} else {
@@ -424,46 +374,61 @@ func addServiceAnnotation(c *kclient.Client, ns string, svc *api.Service, hostNa
 	existingExposeURL := svc.Annotations[exposeAnnotationKey]
 	if existingExposeURL != newExposeURL || hasServiceChanged {
 		util.Infof("ExistingExposeURL %s, newExposeURL %s, hasServiceChanged %v", existingExposeURL, newExposeURL, hasServiceChanged)
+		if svc.Annotations == nil {
+			svc.Annotations = map[string]string{}
+		}
 		svc.Annotations[exposeAnnotationKey] = newExposeURL
-		_, err := c.Services(ns).Update(svc)
+		_, err := c.CoreV1().Services(ns).Update(svc)
 		if err != nil {
 			util.Warnf("Failed to add the %s to service %s %v", exposeAnnotationKey, svc.Name, err)
+			return err
 		}
 		util.Successf("Added %s %s annotation to service %s", newExposeURL, exposeAnnotationKey, svc.Name)
 	}
+	return nil
 }
 
-func createRoute(ns string, domain string, svc *api.Service, c *kclient.Client, oc *osclient.Client) error {
-
-	rapi.AddToScheme(kapi.Scheme)
-	rapiv1.AddToScheme(kapi.Scheme)
+func createRoute(ns string, domain string, svc *corev1.Service, c kubernetes.Interface, routeClient routeclientset.Interface, environment *corev1.ConfigMap) error {
 
+	tls := getTLSSettings(environment)
+	annotations := getIngressAnnotations(svc, environment)
 	name := svc.ObjectMeta.Name
 	// need to add namespace back in the hostname but we have to update the fabric8-console oauthclient too
 	// see https://github.com/fabric8io/gofabric8/issues/98
 	hostName := name + "." + ns + "." + domain
 	//hostName := name + "." + domain
+	routePath := annotations.Path
+	if isPathMode(environment) {
+		hostName = domain
+		routePath = servicePath(ns, name)
+	}
 
 	var labels = make(map[string]string)
 	labels["provider"] = "fabric8"
+	if annotations.IngressClass != "" {
+		// matches the ROUTE_LABELS selector of an HAProxy router shard
+		labels["router"] = annotations.IngressClass
+	}
 
 	serviceLabels := svc.ObjectMeta.Labels
 	exposeLabelKey, exposeLabelValue := getExposeLabel()
 	if serviceLabels[exposeLabelKey] == exposeLabelValue {
 		if name != "kubernetes" {
-			routes := oc.Routes(ns)
-			route, err := routes.Get(name)
+			routes := routeClient.RouteV1().Routes(ns)
+			route, err := routes.Get(name, metav1.GetOptions{})
 			if err != nil {
-				route := rapi.Route{
-					ObjectMeta: kapi.ObjectMeta{
+				route := routev1.Route{
+					ObjectMeta: metav1.ObjectMeta{
 						Labels: labels,
 						Name:   name,
 					},
-					Spec: rapi.RouteSpec{
+					Spec: routev1.RouteSpec{
 						Host: hostName,
-						To:   kapi.ObjectReference{Name: name},
+						Path: routePath,
+						To:   routev1.RouteTargetReference{Kind: "Service", Name: name},
 					},
 				}
+				applyTLSToRoute(&route, tls, svc)
 				// lets create the route
 				_, err = routes.Create(&route)
 				if err != nil {
@@ -471,16 +436,22 @@ func createRoute(ns string, domain string, svc *api.Service, c *kclient.Client,
 					return err
 				}
 				util.Successf("Exposed service %s using openshift route", name)
-			} else if route.Spec.Host != hostName {
+			} else {
+				before := route.DeepCopy()
 				route.Spec.Host = hostName
-				routes.Update(route)
-				util.Successf("Updated route % with hsotname %s", route.Name, hostName)
+				applyTLSToRoute(route, tls, svc)
+				if !reflect.DeepEqual(before.Spec, route.Spec) {
+					if _, err := routes.Update(route); err != nil {
+						log.Printf("Failed to update the route %s with error %v", name, err)
+						return err
+					}
+					util.Successf("Updated route %s with hostname %s", route.Name, hostName)
+				}
 			}
 		}
-		addServiceAnnotation(c, ns, svc, hostName, false)
-	} else {
-		log.Printf("Skipping service %s", name)
+		return addServiceAnnotation(c, ns, svc, hostName+routePath, false, tls.Enabled)
 	}
+	log.Printf("Skipping service %s", name)
 	return nil
 }
 
@@ -0,0 +1,139 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+)
+
+const (
+	exposePathAnnotation           = "fabric8.io/exposePath"
+	exposePortAnnotation           = "fabric8.io/exposePort"
+	rewriteTargetAnnotation        = "fabric8.io/rewriteTarget"
+	whitelistSourceRangeAnnotation = "fabric8.io/whitelistSourceRange"
+	authTypeAnnotation             = "fabric8.io/authType"
+	authSecretAnnotation           = "fabric8.io/authSecret"
+	authRealmAnnotation            = "fabric8.io/authRealm"
+	ingressClassAnnotation         = "fabric8.io/ingressClass"
+
+	ingressClassConfigMapKey = "ingress-class"
+
+	nginxRewriteTargetAnnotation = "nginx.ingress.kubernetes.io/rewrite-target"
+	nginxWhitelistAnnotation     = "nginx.ingress.kubernetes.io/whitelist-source-range"
+	nginxAuthTypeAnnotation      = "nginx.ingress.kubernetes.io/auth-type"
+	nginxAuthSecretAnnotation    = "nginx.ingress.kubernetes.io/auth-secret"
+	nginxAuthRealmAnnotation     = "nginx.ingress.kubernetes.io/auth-realm"
+	kubeIngressClassAnnotation   = "kubernetes.io/ingress.class"
+)
+
+// ingressAnnotations captures the fabric8.io/* annotations a service can carry to
+// influence the generated Ingress/Route beyond the default root-path, single-port rule.
+type ingressAnnotations struct {
+	Path                 string
+	Port                 string
+	RewriteTarget        string
+	WhitelistSourceRange string
+	AuthType             string
+	AuthSecret           string
+	AuthRealm            string
+	IngressClass         string
+}
+
+// getIngressAnnotations reads the fabric8.io/* annotations off the service, falling
+// back to the ConfigMap-level ingress-class when the service doesn't set one.
+func getIngressAnnotations(svc *corev1.Service, environment *corev1.ConfigMap) ingressAnnotations {
+	a := svc.ObjectMeta.Annotations
+	class := a[ingressClassAnnotation]
+	if class == "" {
+		class = environment.Data[ingressClassConfigMapKey]
+	}
+	return ingressAnnotations{
+		Path:                 a[exposePathAnnotation],
+		Port:                 a[exposePortAnnotation],
+		RewriteTarget:        a[rewriteTargetAnnotation],
+		WhitelistSourceRange: a[whitelistSourceRangeAnnotation],
+		AuthType:             a[authTypeAnnotation],
+		AuthSecret:           a[authSecretAnnotation],
+		AuthRealm:            a[authRealmAnnotation],
+		IngressClass:         class,
+	}
+}
+
+// selectServicePort narrows a multi-port service down to the one named or numbered by
+// fabric8.io/exposePort. With no annotation, or a single port, every port is returned
+// unchanged so existing multi-rule behaviour is preserved.
+func selectServicePort(ports []corev1.ServicePort, portAnnotation string) []corev1.ServicePort {
+	if portAnnotation == "" || len(ports) <= 1 {
+		return ports
+	}
+	for _, port := range ports {
+		if port.Name == portAnnotation || strconv.Itoa(int(port.Port)) == portAnnotation {
+			return []corev1.ServicePort{port}
+		}
+	}
+	return ports
+}
+
+// ingressPath returns the fabric8.io/exposePath for a service, defaulting to root.
+// PathType isn't set on the generated HTTPIngressPath: the vendored extensions/v1beta1
+// Ingress API predates that field.
+func ingressPath(a ingressAnnotations) string {
+	if a.Path == "" {
+		return "/"
+	}
+	return a.Path
+}
+
+// applyIngressAnnotations translates the fabric8.io/* passthrough annotations into the
+// concrete nginx-ingress annotations and ingress class on the generated Ingress object.
+// Called on every reconcile, so it also deletes the concrete annotation whenever the
+// fabric8.io/* source value is removed, instead of only ever adding one.
+func applyIngressAnnotations(ingress *extensionsv1beta1.Ingress, a ingressAnnotations) {
+	if ingress.Annotations == nil {
+		ingress.Annotations = map[string]string{}
+	}
+	if a.RewriteTarget != "" {
+		ingress.Annotations[nginxRewriteTargetAnnotation] = a.RewriteTarget
+	} else {
+		delete(ingress.Annotations, nginxRewriteTargetAnnotation)
+	}
+	if a.WhitelistSourceRange != "" {
+		ingress.Annotations[nginxWhitelistAnnotation] = a.WhitelistSourceRange
+	} else {
+		delete(ingress.Annotations, nginxWhitelistAnnotation)
+	}
+	if a.AuthType != "" && a.AuthSecret != "" {
+		ingress.Annotations[nginxAuthTypeAnnotation] = a.AuthType
+		ingress.Annotations[nginxAuthSecretAnnotation] = a.AuthSecret
+		if a.AuthRealm != "" {
+			ingress.Annotations[nginxAuthRealmAnnotation] = a.AuthRealm
+		} else {
+			delete(ingress.Annotations, nginxAuthRealmAnnotation)
+		}
+	} else {
+		delete(ingress.Annotations, nginxAuthTypeAnnotation)
+		delete(ingress.Annotations, nginxAuthSecretAnnotation)
+		delete(ingress.Annotations, nginxAuthRealmAnnotation)
+	}
+	if a.IngressClass != "" {
+		ingress.Annotations[kubeIngressClassAnnotation] = a.IngressClass
+	} else {
+		delete(ingress.Annotations, kubeIngressClassAnnotation)
+	}
+}
@@ -0,0 +1,89 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+)
+
+func TestSelectServicePort(t *testing.T) {
+	ports := []corev1.ServicePort{
+		{Name: "http", Port: 8080},
+		{Name: "https", Port: 8443},
+	}
+
+	if got := selectServicePort(ports, ""); len(got) != 2 {
+		t.Errorf("no annotation: selectServicePort() = %v, want all ports unchanged", got)
+	}
+	if got := selectServicePort(ports[:1], "anything"); len(got) != 1 {
+		t.Errorf("single port: selectServicePort() = %v, want the lone port unchanged", got)
+	}
+	if got := selectServicePort(ports, "https"); len(got) != 1 || got[0].Name != "https" {
+		t.Errorf("select by name: selectServicePort() = %v, want [https]", got)
+	}
+	if got := selectServicePort(ports, "8080"); len(got) != 1 || got[0].Name != "http" {
+		t.Errorf("select by number: selectServicePort() = %v, want [http]", got)
+	}
+	if got := selectServicePort(ports, "nope"); len(got) != 2 {
+		t.Errorf("unmatched annotation: selectServicePort() = %v, want all ports unchanged", got)
+	}
+}
+
+func TestIngressPath(t *testing.T) {
+	if got := ingressPath(ingressAnnotations{}); got != "/" {
+		t.Errorf("ingressPath() with no Path = %q, want /", got)
+	}
+	if got := ingressPath(ingressAnnotations{Path: "/api"}); got != "/api" {
+		t.Errorf("ingressPath() = %q, want /api", got)
+	}
+}
+
+func TestApplyIngressAnnotations(t *testing.T) {
+	ingress := &extensionsv1beta1.Ingress{}
+	applyIngressAnnotations(ingress, ingressAnnotations{
+		RewriteTarget:        "/",
+		WhitelistSourceRange: "10.0.0.0/8",
+		AuthType:             "basic",
+		AuthSecret:           "my-secret",
+		AuthRealm:            "my realm",
+		IngressClass:         "nginx",
+	})
+	want := map[string]string{
+		nginxRewriteTargetAnnotation: "/",
+		nginxWhitelistAnnotation:     "10.0.0.0/8",
+		nginxAuthTypeAnnotation:      "basic",
+		nginxAuthSecretAnnotation:    "my-secret",
+		nginxAuthRealmAnnotation:     "my realm",
+		kubeIngressClassAnnotation:   "nginx",
+	}
+	for k, v := range want {
+		if ingress.Annotations[k] != v {
+			t.Errorf("annotation %s = %q, want %q", k, ingress.Annotations[k], v)
+		}
+	}
+
+	// Removing the fabric8.io/* sources on a later reconcile must delete the concrete
+	// annotations they previously produced, not just leave them stale.
+	applyIngressAnnotations(ingress, ingressAnnotations{})
+	for k := range want {
+		if _, present := ingress.Annotations[k]; present {
+			t.Errorf("annotation %s still present after its source was cleared", k)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fabric8io/exposecontroller/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	nodeIPOverrideKey      = "node-ip-override"
+	nodeAddressStrategyKey = "node-address-strategy"
+
+	nodeStrategyFirst      = "first"
+	nodeStrategyRandom     = "random"
+	nodeStrategyRoundRobin = "round-robin"
+	nodeStrategyAll        = "all"
+)
+
+// nodeCache holds the externally reachable addresses of every Ready node, refreshed by
+// the Node informer in Controller and read by useNodePort on every service reconcile.
+type nodeCache struct {
+	mu        sync.RWMutex
+	addresses []string
+	next      int
+}
+
+func newNodeCache() *nodeCache {
+	return &nodeCache{}
+}
+
+// update replaces the cached address list from a fresh Node listing. Nodes that aren't
+// Ready are dropped so exposeUrl never advertises a node that can't serve traffic.
+func (nc *nodeCache) update(nodes *corev1.NodeList, override string) {
+	addresses := []string{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !nodeIsReady(node) {
+			continue
+		}
+		if address := nodeAddress(node, override); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.addresses = addresses
+}
+
+// pick returns the address(es) useNodePort should expose a service on, per strategy.
+func (nc *nodeCache) pick(strategy string) []string {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if len(nc.addresses) == 0 {
+		return nil
+	}
+
+	switch strategy {
+	case nodeStrategyAll:
+		return append([]string{}, nc.addresses...)
+	case nodeStrategyRandom:
+		return []string{nc.addresses[rand.Intn(len(nc.addresses))]}
+	case nodeStrategyRoundRobin:
+		address := nc.addresses[nc.next%len(nc.addresses)]
+		nc.next++
+		return []string{address}
+	default:
+		return []string{nc.addresses[0]}
+	}
+}
+
+func nodeIsReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nodeAddress picks the address to advertise for a node: its ExternalIP, falling back to
+// its InternalIP, falling back to the ConfigMap-provided node-ip-override.
+func nodeAddress(node *corev1.Node, override string) string {
+	var internal string
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case corev1.NodeExternalIP:
+			if address.Address != "" {
+				return address.Address
+			}
+		case corev1.NodeInternalIP:
+			if internal == "" {
+				internal = address.Address
+			}
+		}
+	}
+	if internal != "" {
+		return internal
+	}
+	return override
+}
+
+func getNodeIPOverride(c kubernetes.Interface, currentNs string) string {
+	environment, err := c.CoreV1().ConfigMaps(currentNs).Get(exposeControllerCM, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return environment.Data[nodeIPOverrideKey]
+}
+
+// hasReadyEndpoints reports whether name has at least one Ready endpoint address, so
+// useNodePort can avoid advertising a URL that would currently 502. It reads through the
+// Endpoints informer's lister rather than a live Get so a readiness flip also re-triggers
+// this check via the informer's own event handler, instead of only being noticed on the
+// next service add/update/delete.
+func hasReadyEndpoints(endpointsLister corelisters.EndpointsLister, ns string, name string) (bool, error) {
+	endpoints, err := endpointsLister.Endpoints(ns).Get(name)
+	if err != nil {
+		return false, err
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func useNodePort(ns string, svc *corev1.Service, c kubernetes.Interface, nodes *nodeCache, environment *corev1.ConfigMap, endpointsLister corelisters.EndpointsLister) error {
+	serviceLabels := svc.ObjectMeta.Labels
+	exposeLabelKey, exposeLabelValue := getExposeLabel()
+	if serviceLabels[exposeLabelKey] != exposeLabelValue {
+		log.Printf("Skipping service %s", svc.ObjectMeta.Name)
+		return nil
+	}
+
+	if svc.Spec.Type != corev1.ServiceTypeNodePort {
+		svc.Spec.Type = corev1.ServiceTypeNodePort
+		// Update now, rather than deferring to the addServiceAnnotationURL call below: the
+		// API server only allocates svc.Spec.Ports[].NodePort inside this Update, and the
+		// URLs built further down read it straight off the in-memory object. Without this,
+		// the first ClusterIP->NodePort reconcile would advertise a URL with port 0.
+		updatedSvc, err := c.CoreV1().Services(ns).Update(svc)
+		if err != nil {
+			return err
+		}
+		svc = updatedSvc
+	}
+	if len(svc.Spec.Ports) > 1 {
+		util.Warnf("Found %v ports %s", len(svc.Spec.Ports), svc.Name)
+	}
+
+	ready, err := hasReadyEndpoints(endpointsLister, ns, svc.ObjectMeta.Name)
+	if err != nil {
+		util.Warnf("Unable to check endpoints for service %s %v", svc.ObjectMeta.Name, err)
+	}
+	if !ready {
+		log.Printf("Service %s has no Ready endpoints yet, not advertising a NodePort URL", svc.ObjectMeta.Name)
+		return nil
+	}
+
+	strategy := environment.Data[nodeAddressStrategyKey]
+	if strategy == "" {
+		strategy = nodeStrategyFirst
+	}
+	addresses := nodes.pick(strategy)
+	if len(addresses) == 0 {
+		util.Errorf("No Ready nodes with a usable address found; unable to annotate service %s", svc.Name)
+		return nil
+	}
+
+	urls := []string{}
+	for _, port := range svc.Spec.Ports {
+		nodePortStr := strconv.Itoa(int(port.NodePort))
+		protocol := "http"
+		if nodePortStr == "443" || nodePortStr == "8443" || port.Name == "https" {
+			protocol = "https"
+		}
+		for _, address := range addresses {
+			urls = append(urls, protocol+"://"+address+":"+nodePortStr)
+		}
+	}
+
+	util.Successf("Updating service %s using NodePort", svc.ObjectMeta.Name)
+	return addServiceAnnotationURL(c, ns, svc, strings.Join(urls, ","), false)
+}
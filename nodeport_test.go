@@ -0,0 +1,109 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNodeCachePick(t *testing.T) {
+	nc := newNodeCache()
+	if addresses := nc.pick(nodeStrategyFirst); addresses != nil {
+		t.Fatalf("pick on an empty cache = %v, want nil", addresses)
+	}
+
+	nc.addresses = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	if got := nc.pick(nodeStrategyFirst); len(got) != 1 || got[0] != "10.0.0.1" {
+		t.Errorf("pick(%s) = %v, want [10.0.0.1]", nodeStrategyFirst, got)
+	}
+	if got := nc.pick(nodeStrategyAll); len(got) != 3 {
+		t.Errorf("pick(%s) = %v, want all 3 addresses", nodeStrategyAll, got)
+	}
+	if got := nc.pick(nodeStrategyRandom); len(got) != 1 {
+		t.Errorf("pick(%s) = %v, want exactly one address", nodeStrategyRandom, got)
+	}
+
+	first := nc.pick(nodeStrategyRoundRobin)
+	second := nc.pick(nodeStrategyRoundRobin)
+	third := nc.pick(nodeStrategyRoundRobin)
+	fourth := nc.pick(nodeStrategyRoundRobin)
+	if first[0] != "10.0.0.1" || second[0] != "10.0.0.2" || third[0] != "10.0.0.3" {
+		t.Errorf("round-robin sequence = %v, %v, %v, want 10.0.0.1, 10.0.0.2, 10.0.0.3", first, second, third)
+	}
+	if fourth[0] != first[0] {
+		t.Errorf("round-robin did not wrap around: got %v after a full cycle", fourth)
+	}
+}
+
+func TestNodeIsReady(t *testing.T) {
+	cases := []struct {
+		name string
+		node *corev1.Node
+		want bool
+	}{
+		{"ready", &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+		}}}, true},
+		{"not ready", &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+		}}}, false},
+		{"no ready condition", &corev1.Node{}, false},
+	}
+	for _, tc := range cases {
+		if got := nodeIsReady(tc.node); got != tc.want {
+			t.Errorf("%s: nodeIsReady() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNodeAddress(t *testing.T) {
+	cases := []struct {
+		name     string
+		node     *corev1.Node
+		override string
+		want     string
+	}{
+		{
+			name: "prefers external IP over internal",
+			node: &corev1.Node{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: corev1.NodeExternalIP, Address: "1.2.3.4"},
+			}}},
+			want: "1.2.3.4",
+		},
+		{
+			name: "falls back to internal IP",
+			node: &corev1.Node{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			}}},
+			want: "10.0.0.1",
+		},
+		{
+			name:     "falls back to override with no addresses",
+			node:     &corev1.Node{},
+			override: "override.example.com",
+			want:     "override.example.com",
+		},
+	}
+	for _, tc := range cases {
+		if got := nodeAddress(tc.node, tc.override); got != tc.want {
+			t.Errorf("%s: nodeAddress() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
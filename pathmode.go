@@ -0,0 +1,149 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/fabric8io/exposecontroller/util"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	urlTemplate          = "url-template"
+	defaultURLTemplate   = "{name}.{namespace}.{domain}"
+	pathURLTemplate      = "{domain}/{namespace}/{name}"
+	sharedIngressName    = "exposecontroller-shared"
+	rewriteTargetForRoot = "/"
+)
+
+// isPathMode reports whether the exposecontroller ConfigMap asks for every service to be
+// exposed under a single shared hostname, path-routed by namespace and name, rather than
+// the default one-hostname-per-service scheme.
+func isPathMode(environment *corev1.ConfigMap) bool {
+	return environment.Data[urlTemplate] == pathURLTemplate
+}
+
+// servicePath is the {namespace}/{name}/ path a service is exposed under in path mode.
+func servicePath(ns, name string) string {
+	return fmt.Sprintf("/%s/%s/", ns, name)
+}
+
+// reconcileSharedIngress computes the desired Ingress for every expose=true service in ns
+// - one shared host (domain) with a path per service - diffs it against the live object,
+// and applies the difference. It is re-run on every add/update/delete of a service in ns
+// so the shared Ingress always reflects the current set of exposed services.
+func reconcileSharedIngress(ns string, domain string, c kubernetes.Interface, environment *corev1.ConfigMap) error {
+	ingressClient := c.ExtensionsV1beta1().Ingresses(ns)
+	tls := getTLSSettings(environment)
+
+	services, err := c.CoreV1().Services(ns).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list services in namespace %s: %v", ns, err)
+	}
+
+	exposeLabelKey, exposeLabelValue := getExposeLabel()
+	paths := []extensionsv1beta1.HTTPIngressPath{}
+	exposed := []*corev1.Service{}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.ObjectMeta.Labels[exposeLabelKey] != exposeLabelValue {
+			continue
+		}
+		annotations := getIngressAnnotations(svc, environment)
+		ports := selectServicePort(svc.Spec.Ports, annotations.Port)
+		if len(ports) == 0 {
+			continue
+		}
+		paths = append(paths, extensionsv1beta1.HTTPIngressPath{
+			Path: servicePath(ns, svc.ObjectMeta.Name),
+			Backend: extensionsv1beta1.IngressBackend{
+				ServiceName: svc.ObjectMeta.Name,
+				ServicePort: ports[0].TargetPort,
+			},
+		})
+		exposed = append(exposed, svc)
+	}
+
+	existing, getErr := ingressClient.Get(sharedIngressName, metav1.GetOptions{})
+	if len(paths) == 0 {
+		if getErr == nil {
+			if err := ingressClient.Delete(sharedIngressName, nil); err != nil {
+				return fmt.Errorf("failed to delete shared ingress %s: %v", sharedIngressName, err)
+			}
+			util.Successf("Deleted shared ingress %s in namespace %s, no services left to expose", sharedIngressName, ns)
+		}
+		return nil
+	}
+
+	desiredSpec := extensionsv1beta1.IngressSpec{
+		Rules: []extensionsv1beta1.IngressRule{
+			{
+				Host: domain,
+				IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+					HTTP: &extensionsv1beta1.HTTPIngressRuleValue{Paths: paths},
+				},
+			},
+		},
+	}
+
+	if getErr != nil {
+		ingress := &extensionsv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        sharedIngressName,
+				Labels:      map[string]string{"provider": "fabric8"},
+				Annotations: map[string]string{nginxRewriteTargetAnnotation: rewriteTargetForRoot},
+			},
+			Spec: desiredSpec,
+		}
+		applyTLSToIngress(ingress, tls, domain, ns)
+		if _, err := ingressClient.Create(ingress); err != nil {
+			return fmt.Errorf("failed to create shared ingress %s: %v", sharedIngressName, err)
+		}
+		util.Successf("Created shared ingress %s in namespace %s for %d services", sharedIngressName, ns, len(exposed))
+	} else {
+		// Mutate the live object in place and diff against a copy, rather than building a
+		// separate desired object and replacing Annotations wholesale, so an annotation set
+		// by something other than this function survives the reconcile.
+		before := existing.DeepCopy()
+		existing.Spec = desiredSpec
+		if existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
+		}
+		existing.Annotations[nginxRewriteTargetAnnotation] = rewriteTargetForRoot
+		applyTLSToIngress(existing, tls, domain, ns)
+		if !reflect.DeepEqual(before.Spec, existing.Spec) || !reflect.DeepEqual(before.Annotations, existing.Annotations) {
+			if _, err := ingressClient.Update(existing); err != nil {
+				return fmt.Errorf("failed to update shared ingress %s: %v", sharedIngressName, err)
+			}
+			util.Successf("Updated shared ingress %s in namespace %s for %d services", sharedIngressName, ns, len(exposed))
+		}
+	}
+
+	protocol := "http"
+	if tls.Enabled {
+		protocol = "https"
+	}
+	for _, svc := range exposed {
+		url := protocol + "://" + domain + servicePath(ns, svc.ObjectMeta.Name)
+		addServiceAnnotationURL(c, ns, svc, url, false)
+	}
+	return nil
+}
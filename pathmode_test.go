@@ -0,0 +1,79 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestIsPathMode(t *testing.T) {
+	if isPathMode(&corev1.ConfigMap{Data: map[string]string{urlTemplate: defaultURLTemplate}}) {
+		t.Error("isPathMode() = true for the default url-template")
+	}
+	if !isPathMode(&corev1.ConfigMap{Data: map[string]string{urlTemplate: pathURLTemplate}}) {
+		t.Error("isPathMode() = false for the path url-template")
+	}
+}
+
+func TestServicePath(t *testing.T) {
+	if got := servicePath("myns", "myapp"); got != "/myns/myapp/" {
+		t.Errorf("servicePath() = %q, want /myns/myapp/", got)
+	}
+}
+
+func exposedService(name string) *corev1.Service {
+	labelKey, labelValue := getExposeLabel()
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{labelKey: labelValue},
+		},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+	}
+}
+
+func TestReconcileSharedIngressCreatesThenNoOps(t *testing.T) {
+	svc := exposedService("myapp")
+	client := fake.NewSimpleClientset(svc)
+	environment := &corev1.ConfigMap{}
+
+	if err := reconcileSharedIngress("default", "example.com", client, environment); err != nil {
+		t.Fatalf("reconcileSharedIngress() error = %v", err)
+	}
+	ingress, err := client.ExtensionsV1beta1().Ingresses("default").Get(sharedIngressName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the shared ingress to be created, Get() error = %v", err)
+	}
+	if len(ingress.Spec.Rules) != 1 || len(ingress.Spec.Rules[0].HTTP.Paths) != 1 {
+		t.Fatalf("Spec.Rules = %+v, want one rule with one path for myapp", ingress.Spec.Rules)
+	}
+
+	// A second reconcile of the same desired state must not re-Update the ingress.
+	client.PrependReactor("update", "ingresses", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		t.Fatalf("unexpected Update on an unchanged shared ingress")
+		return false, nil, nil
+	})
+	if err := reconcileSharedIngress("default", "example.com", client, environment); err != nil {
+		t.Fatalf("reconcileSharedIngress() second call error = %v", err)
+	}
+}
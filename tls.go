@@ -0,0 +1,172 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"log"
+
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	tlsKey        = "tls"
+	tlsAcme       = "tls-acme"
+	tlsSecretName = "tls-secret-name"
+	tlsEmail      = "tls-email"
+	tlsProvider   = "tls-provider"
+
+	tlsAcmeAnnotation          = "kubernetes.io/tls-acme"
+	tlsClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+)
+
+// tlsSettings is read from the exposecontroller ConfigMap and controls whether generated
+// Ingress/Route objects request HTTPS, and how the certificate should be provisioned.
+type tlsSettings struct {
+	Enabled    bool
+	ACME       bool
+	SecretName string
+	Email      string
+	Provider   string
+}
+
+func getTLSSettings(environment *corev1.ConfigMap) tlsSettings {
+	return tlsSettings{
+		Enabled:    environment.Data[tlsKey] == "true",
+		ACME:       environment.Data[tlsAcme] == "true",
+		SecretName: environment.Data[tlsSecretName],
+		Email:      environment.Data[tlsEmail],
+		Provider:   environment.Data[tlsProvider],
+	}
+}
+
+// warnIfACMEFallbackUnavailable logs once at process startup if tls is enabled without
+// cert-manager or tls-acme configured. exposecontroller does not run its own ACME client
+// (see the ACMEClient doc comment) - that was descoped from its original request, not
+// implemented - so in this configuration no certificate is ever provisioned automatically.
+// Surfacing that at startup, rather than waiting for applyTLSToIngress's per-reconcile log
+// line on the first TLS-enabled service, makes the gap visible to whoever deploys this.
+func warnIfACMEFallbackUnavailable(c kubernetes.Interface, currentNs string) {
+	environment, err := c.CoreV1().ConfigMaps(currentNs).Get(exposeControllerCM, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	tls := getTLSSettings(environment)
+	if tls.Enabled && !tls.ACME && tls.Provider == "" {
+		log.Printf("WARNING: %s is true but neither %s nor %s is set; exposecontroller has no built-in ACME client, so no TLS certificate will be provisioned unless you pre-provision each Ingress/Route's Secret yourself", tlsKey, tlsAcme, tlsProvider)
+	}
+}
+
+// applyTLSToIngress adds a Spec.TLS entry referencing the configured (or service-name
+// derived) secret, plus the annotations cert-manager and ingress controllers expect to
+// provision the certificate. If neither cert-manager nor tls-acme is configured there is
+// no in-process fallback that can populate that secret (see ACMEClient); in that case the
+// operator must pre-provision it, and applyTLSToIngress logs that the secret is missing
+// a provisioner rather than silently leaving the Ingress referencing an empty Secret.
+// Called on every reconcile of an Ingress, so it also clears Spec.TLS and the TLS
+// annotations when tls.Enabled is false, undoing a previous run where it was true.
+func applyTLSToIngress(ingress *extensionsv1beta1.Ingress, tls tlsSettings, hostName string, ns string) {
+	if !tls.Enabled {
+		ingress.Spec.TLS = nil
+		delete(ingress.Annotations, tlsAcmeAnnotation)
+		delete(ingress.Annotations, tlsClusterIssuerAnnotation)
+		return
+	}
+
+	secretName := tls.SecretName
+	if secretName == "" {
+		secretName = ingress.Name + "-tls"
+	}
+
+	ingress.Spec.TLS = []extensionsv1beta1.IngressTLS{
+		{
+			Hosts:      []string{hostName},
+			SecretName: secretName,
+		},
+	}
+
+	if ingress.Annotations == nil {
+		ingress.Annotations = map[string]string{}
+	}
+	if tls.ACME {
+		ingress.Annotations[tlsAcmeAnnotation] = "true"
+	} else {
+		delete(ingress.Annotations, tlsAcmeAnnotation)
+	}
+	if tls.Provider != "" {
+		ingress.Annotations[tlsClusterIssuerAnnotation] = tls.Provider
+	} else {
+		delete(ingress.Annotations, tlsClusterIssuerAnnotation)
+	}
+
+	if !tls.ACME && tls.Provider == "" {
+		if err := NewLegoACMEClient(tls.Email).EnsureCertificate(ns, secretName, hostName); err != nil {
+			log.Printf("Unable to provision TLS certificate for %s: %v. Set %s or %s, or create %s/%s yourself.", hostName, err, tlsAcme, tlsProvider, ns, secretName)
+		}
+	}
+}
+
+// applyTLSToRoute sets Spec.TLS on an OpenShift route. Services with a port named "https"
+// get passthrough-to-the-pod reencrypt termination, everything else terminates at the edge.
+// Called on every reconcile of a route, so it also clears Spec.TLS when tls.Enabled is
+// false, undoing a previous run where it was true.
+func applyTLSToRoute(route *routev1.Route, tls tlsSettings, svc *corev1.Service) {
+	if !tls.Enabled {
+		route.Spec.TLS = nil
+		return
+	}
+
+	termination := routev1.TLSTerminationEdge
+	for _, port := range svc.Spec.Ports {
+		if port.Name == "https" {
+			termination = routev1.TLSTerminationReencrypt
+		}
+	}
+
+	route.Spec.TLS = &routev1.TLSConfig{
+		Termination:                   termination,
+		InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyRedirect,
+	}
+}
+
+// ACMEClient provisions a TLS certificate for hostName directly into the named Secret, for
+// use when cert-manager isn't installed on the cluster. It is an extension point, not a
+// delivered provider: exposecontroller doesn't vendor an ACME library or run an HTTP-01
+// challenge listener, so the default implementation (legoACMEClient) always declines and
+// logs what to configure instead. Wire in a real HTTP-01 client (e.g. go-acme/lego) behind
+// this interface, and pass it to applyTLSToIngress in place of NewLegoACMEClient, once this
+// controller has somewhere to serve challenge responses from.
+type ACMEClient interface {
+	EnsureCertificate(ns, secretName, hostName string) error
+}
+
+// legoACMEClient is the no-op default ACMEClient: see the ACMEClient doc comment for why.
+type legoACMEClient struct {
+	email string
+}
+
+// NewLegoACMEClient returns the default ACMEClient. It does not provision anything.
+func NewLegoACMEClient(email string) ACMEClient {
+	return &legoACMEClient{email: email}
+}
+
+func (l *legoACMEClient) EnsureCertificate(ns, secretName, hostName string) error {
+	return fmt.Errorf("no ACME provider configured for %s; exposecontroller does not run one itself, install cert-manager (%s) or provision %s/%s yourself", hostName, tlsAcme, ns, secretName)
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+)
+
+func TestApplyTLSToIngress(t *testing.T) {
+	ingress := &extensionsv1beta1.Ingress{}
+	ingress.Name = "myapp"
+
+	applyTLSToIngress(ingress, tlsSettings{Enabled: true, ACME: true, Provider: "letsencrypt"}, "myapp.example.com", "default")
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "myapp-tls" {
+		t.Fatalf("Spec.TLS = %v, want one entry for myapp-tls", ingress.Spec.TLS)
+	}
+	if ingress.Annotations[tlsAcmeAnnotation] != "true" {
+		t.Errorf("%s = %q, want true", tlsAcmeAnnotation, ingress.Annotations[tlsAcmeAnnotation])
+	}
+	if ingress.Annotations[tlsClusterIssuerAnnotation] != "letsencrypt" {
+		t.Errorf("%s = %q, want letsencrypt", tlsClusterIssuerAnnotation, ingress.Annotations[tlsClusterIssuerAnnotation])
+	}
+
+	// Flipping tls-acme off (while tls itself stays enabled) must clear the stale annotation.
+	applyTLSToIngress(ingress, tlsSettings{Enabled: true, Provider: "letsencrypt"}, "myapp.example.com", "default")
+	if _, present := ingress.Annotations[tlsAcmeAnnotation]; present {
+		t.Errorf("%s still set after tls-acme was disabled", tlsAcmeAnnotation)
+	}
+	if ingress.Annotations[tlsClusterIssuerAnnotation] != "letsencrypt" {
+		t.Errorf("%s = %q, want letsencrypt to survive the tls-acme flip", tlsClusterIssuerAnnotation, ingress.Annotations[tlsClusterIssuerAnnotation])
+	}
+
+	// Disabling tls entirely must clear Spec.TLS and every TLS annotation.
+	applyTLSToIngress(ingress, tlsSettings{Enabled: false}, "myapp.example.com", "default")
+	if ingress.Spec.TLS != nil {
+		t.Errorf("Spec.TLS = %v, want nil once tls is disabled", ingress.Spec.TLS)
+	}
+	if _, present := ingress.Annotations[tlsClusterIssuerAnnotation]; present {
+		t.Errorf("%s still set after tls was disabled", tlsClusterIssuerAnnotation)
+	}
+}
+
+func TestApplyTLSToRoute(t *testing.T) {
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http"}}}}
+
+	route := &routev1.Route{}
+	applyTLSToRoute(route, tlsSettings{Enabled: true}, svc)
+	if route.Spec.TLS == nil || route.Spec.TLS.Termination != routev1.TLSTerminationEdge {
+		t.Fatalf("Spec.TLS = %v, want edge termination for a plain http service", route.Spec.TLS)
+	}
+
+	httpsSvc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "https"}}}}
+	applyTLSToRoute(route, tlsSettings{Enabled: true}, httpsSvc)
+	if route.Spec.TLS.Termination != routev1.TLSTerminationReencrypt {
+		t.Errorf("Termination = %v, want reencrypt for a service with an https port", route.Spec.TLS.Termination)
+	}
+
+	applyTLSToRoute(route, tlsSettings{Enabled: false}, svc)
+	if route.Spec.TLS != nil {
+		t.Errorf("Spec.TLS = %v, want nil once tls is disabled", route.Spec.TLS)
+	}
+}